@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultClientMaxAge is the default lifetime of a govmomi client before
+	// ClientFactory transparently recycles it.
+	DefaultClientMaxAge = 30 * time.Minute
+	// DefaultKeepAliveInterval is the default interval at which the keepalive
+	// round tripper pings vCenter to keep an idle session from being reaped.
+	DefaultKeepAliveInterval = 5 * time.Minute
+)
+
+// ClientFactory hands out a single govmomi Client shared across goroutines
+// for a VirtualCenter. It transparently recycles the client once it reaches
+// MaxAge and wraps it with a session.KeepAlive round tripper so idle
+// sessions aren't garbage collected by vCenter. This avoids the
+// "invalid credentials"/"not authenticated" churn that callers otherwise
+// only discover after an RPC fails.
+type ClientFactory struct {
+	vc                *VirtualCenter
+	MaxAge            time.Duration
+	KeepAliveInterval time.Duration
+
+	mu        sync.Mutex
+	client    *govmomi.Client
+	createdAt time.Time
+}
+
+// NewClientFactory creates a ClientFactory for vc. A maxAge or
+// keepAliveInterval of 0 falls back to the package defaults.
+func NewClientFactory(vc *VirtualCenter, maxAge, keepAliveInterval time.Duration) *ClientFactory {
+	if maxAge == 0 {
+		maxAge = DefaultClientMaxAge
+	}
+	if keepAliveInterval == 0 {
+		keepAliveInterval = DefaultKeepAliveInterval
+	}
+	return &ClientFactory{
+		vc:                vc,
+		MaxAge:            maxAge,
+		KeepAliveInterval: keepAliveInterval,
+	}
+}
+
+// GetClient returns a live govmomi Client for the factory's VirtualCenter,
+// creating or recycling it as needed. Concurrent callers share the same
+// client instance until it is recycled, either because MaxAge elapsed or
+// because the underlying session is no longer valid. This is the only path
+// that should ever build a new govmomi Client for a VirtualCenter.
+func (cf *ClientFactory) GetClient(ctx context.Context) (*govmomi.Client, error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.client != nil && time.Since(cf.createdAt) < cf.MaxAge {
+		sessionMgr := session.NewManager(cf.client.Client)
+		// SessionMgr.UserSession(ctx) retrieves and returns the SessionManager's
+		// CurrentSession field. Nil is returned if the session is not
+		// authenticated or timed out.
+		userSession, err := sessionMgr.UserSession(ctx)
+		if err != nil {
+			klog.Errorf("Failed to obtain user session for %s with err: %v", cf.vc.Config.Host, err)
+			return nil, err
+		}
+		if userSession != nil {
+			return cf.client, nil
+		}
+		klog.Warning("Creating a new client session as the existing session isn't valid or not authenticated")
+	} else if cf.client != nil {
+		klog.V(2).Infof("Recycling govmomi client for %s after %s to dodge session expiration",
+			cf.vc.Config.Host, time.Since(cf.createdAt))
+	}
+
+	// The keepalive ticker outlives this call's ctx (a single GetClient
+	// caller's RPC), so it must ping with its own long-lived context rather
+	// than one that gets canceled as soon as that caller returns. It's
+	// wired in by newClient itself, ahead of login, so the login round trip
+	// is what arms KeepAliveHandler's ticker.
+	client, err := cf.vc.newClient(ctx, cf.KeepAliveInterval, cf.keepAliveHandler(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	cf.logoutLocked(ctx)
+	// The datacenter/hosts-by-cluster caches hold objects built on top of
+	// the client we just logged out; serving them past this point would
+	// mean serving objects that point at a dead session until their TTL
+	// expires.
+	cf.vc.refreshInventoryCaches(ctx)
+	cf.client = client
+	cf.createdAt = time.Now()
+	return cf.client, nil
+}
+
+// logoutLocked logs out the currently cached client, if any, so its
+// vCenter session and keepalive goroutine don't outlive its replacement.
+// Callers must hold cf.mu.
+func (cf *ClientFactory) logoutLocked(ctx context.Context) {
+	if cf.client == nil {
+		return
+	}
+	if err := cf.client.Logout(ctx); err != nil {
+		klog.Errorf("Failed to logout stale govmomi client for %s with err: %v", cf.vc.Config.Host, err)
+	}
+}
+
+// Invalidate discards the cached client so the next GetClient call logs in
+// again from scratch, instead of reusing a session that's still valid by
+// SessionManager's account but was authenticated under credentials that
+// have since been rotated. A credential rotation doesn't itself invalidate
+// an already-established SOAP session, so GetClient's session-validity
+// check alone can't detect it; callers that know credentials changed (e.g.
+// CredentialWatcher) must call Invalidate explicitly.
+func (cf *ClientFactory) Invalidate() {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	cf.logoutLocked(context.Background())
+	cf.client = nil
+}
+
+// keepAliveHandler returns a KeepAliveHandler callback that pings vCenter
+// with GetCurrentTime on every tick so an otherwise idle session stays
+// alive.
+func (cf *ClientFactory) keepAliveHandler(ctx context.Context) func(soap.RoundTripper) error {
+	return func(rt soap.RoundTripper) error {
+		_, err := methods.GetCurrentTime(ctx, rt)
+		if err != nil {
+			klog.Errorf("Keepalive ping failed for %s with err: %v", cf.vc.Config.Host, err)
+		}
+		return err
+	}
+}
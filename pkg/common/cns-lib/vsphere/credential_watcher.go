@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+// CredentialWatcher watches the vSphere credential Secret and proactively
+// refreshes a VirtualCenter's credentials when it changes, so a rotation is
+// picked up before any RPC has a chance to fail with invalid credentials.
+type CredentialWatcher struct {
+	vc         *VirtualCenter
+	secretName string
+	informer   cache.SharedIndexInformer
+
+	mu           sync.Mutex
+	lastUsername string
+	lastPassword string
+	lastCert     string
+	lastKey      string
+}
+
+// NewCredentialWatcher creates a CredentialWatcher for vc, backed by
+// informer, which must already be configured to watch the Secret named
+// secretName.
+func NewCredentialWatcher(vc *VirtualCenter, secretName string, informer cache.SharedIndexInformer) *CredentialWatcher {
+	w := &CredentialWatcher{vc: vc, secretName: secretName, informer: informer}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.handle(obj) },
+		UpdateFunc: func(_, obj interface{}) { w.handle(obj) },
+	})
+	return w
+}
+
+// Run starts the underlying informer and blocks until its cache has synced.
+func (w *CredentialWatcher) Run(stopCh <-chan struct{}) {
+	go w.informer.Run(stopCh)
+	cache.WaitForCacheSync(stopCh, w.informer.HasSynced)
+}
+
+// handle coalesces rapid Secret updates: if the credential fields for the
+// VirtualCenter's AuthMode haven't actually changed since the last observed
+// revision, it's a no-op.
+func (w *CredentialWatcher) handle(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok || secret.Name != w.secretName {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch w.vc.Config.AuthMode {
+	case AuthModeSolutionUser:
+		w.handleSolutionUserLocked(secret)
+	case AuthModeBearerTokenFile:
+		// BearerTokenFileAuth re-reads its token file on every Login, so
+		// there's no in-memory credential here for a Secret event to push;
+		// nothing to do.
+	default:
+		w.handleUserPasswordLocked(secret)
+	}
+}
+
+// handleUserPasswordLocked applies a username/password Secret update for an
+// AuthModeUserPassword VirtualCenter. w.mu must be held.
+func (w *CredentialWatcher) handleUserPasswordLocked(secret *corev1.Secret) {
+	username := string(secret.Data["username"])
+	password := string(secret.Data["password"])
+	if username == w.lastUsername && password == w.lastPassword {
+		return
+	}
+	klog.V(2).Infof("Detected credential rotation in secret %q for vCenter %s, username changed: %v",
+		w.secretName, w.vc.Config.Host, username != w.lastUsername)
+	w.lastUsername, w.lastPassword = username, password
+
+	w.vc.UpdateCredentials(username, password)
+	w.vc.invalidateClient()
+}
+
+// handleSolutionUserLocked applies a PEM-encoded cert/key Secret update for
+// an AuthModeSolutionUser VirtualCenter, the same cert/key path Login
+// already handles for the config-file case. w.mu must be held.
+func (w *CredentialWatcher) handleSolutionUserLocked(secret *corev1.Secret) {
+	cert := string(secret.Data["cert"])
+	key := string(secret.Data["key"])
+	if cert == w.lastCert && key == w.lastKey {
+		return
+	}
+	klog.V(2).Infof("Detected solution-user credential rotation in secret %q for vCenter %s",
+		w.secretName, w.vc.Config.Host)
+	w.lastCert, w.lastKey = cert, key
+
+	w.vc.UpdateSolutionUserCredentials(cert, key)
+	w.vc.invalidateClient()
+}
+
+// FileConfigWatcher watches the CSI cloud config file for changes using
+// fsnotify, as an alternative to CredentialWatcher for installs that mount
+// credentials via the config file rather than a Secret.
+type FileConfigWatcher struct {
+	vc      *VirtualCenter
+	cfgPath string
+	watcher *fsnotify.Watcher
+}
+
+// NewFileConfigWatcher creates a FileConfigWatcher for vc that watches
+// cfgPath for changes.
+func NewFileConfigWatcher(vc *VirtualCenter, cfgPath string) (*FileConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(cfgPath); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &FileConfigWatcher{vc: vc, cfgPath: cfgPath, watcher: watcher}, nil
+}
+
+// Run processes fsnotify events for the watched config file until stopCh is
+// closed.
+func (w *FileConfigWatcher) Run(stopCh <-chan struct{}) {
+	defer w.watcher.Close()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			w.reload()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.Errorf("fsnotify watcher error for %s: %v", w.cfgPath, err)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// reload re-reads the config file and pushes any credential change to the
+// VirtualCenter, forcing a re-login on the next Connect.
+func (w *FileConfigWatcher) reload() {
+	cfg, err := cnsconfig.GetCnsconfig(w.cfgPath)
+	if err != nil {
+		klog.Errorf("Failed to reload config %s after change with err: %v", w.cfgPath, err)
+		return
+	}
+	vcenterConfig, err := GetVirtualCenterConfig(cfg)
+	if err != nil {
+		klog.Errorf("Failed to parse VirtualCenterConfig from %s with err: %v", w.cfgPath, err)
+		return
+	}
+	klog.V(2).Infof("Detected config change in %s, refreshing credentials for vCenter %s", w.cfgPath, w.vc.Config.Host)
+	w.vc.updateCredentialsFromConfig(vcenterConfig)
+	w.vc.invalidateClient()
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testSecretNamespace = "kube-system"
+
+// newFakeSecretInformer builds a SharedIndexInformer backed by a fake
+// clientset, standing in for the real informer a driver would get from an
+// informer factory watching the vSphere credential Secret.
+func newFakeSecretInformer(client *fake.Clientset) cache.SharedIndexInformer {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Secrets(testSecretNamespace).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Secrets(testSecretNamespace).Watch(context.Background(), options)
+		},
+	}
+	return cache.NewSharedIndexInformer(lw, &corev1.Secret{}, 0, cache.Indexers{})
+}
+
+func waitForCondition(t *testing.T, condition func() bool) {
+	t.Helper()
+	err := wait.PollImmediate(10*time.Millisecond, 2*time.Second, func() (bool, error) {
+		return condition(), nil
+	})
+	if err != nil {
+		t.Fatalf("condition was never satisfied: %v", err)
+	}
+}
+
+func TestCredentialWatcherUpdatesCredentialsOnSecretChange(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	vc := &VirtualCenter{
+		Config: &VirtualCenterConfig{Host: "vc.test", Username: "olduser", Password: "oldpass"},
+		Client: &govmomi.Client{},
+	}
+
+	informer := newFakeSecretInformer(client)
+	NewCredentialWatcher(vc, "vsphere-creds", informer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("informer cache never synced")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsphere-creds", Namespace: testSecretNamespace},
+		Data: map[string][]byte{
+			"username": []byte("newuser"),
+			"password": []byte("newpass"),
+		},
+	}
+	if _, err := client.CoreV1().Secrets(testSecretNamespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	waitForCondition(t, func() bool {
+		return vc.Config.Username == "newuser" && vc.Config.Password == "newpass"
+	})
+	waitForCondition(t, func() bool {
+		return vc.Client == nil
+	})
+}
+
+// TestCredentialWatcherForcesRelogin guards against the bug where nulling
+// vc.Client alone didn't force a re-login: connect() no longer decides
+// whether to re-authenticate from vc.Client, it delegates to
+// vc.clientFactory's own cached client, which a credential rotation alone
+// doesn't invalidate. A real end-to-end Connect() needs a live vCenter, so
+// this asserts the fix at the level the bug actually lived at: that the
+// watcher clears clientFactory's cached client too, which is what makes the
+// next GetClient() call build a fresh, re-authenticated client rather than
+// handing back the stale one.
+func TestCredentialWatcherForcesRelogin(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	vc := &VirtualCenter{
+		Config: &VirtualCenterConfig{Host: "vc.test2", Username: "olduser", Password: "oldpass"},
+		Client: &govmomi.Client{},
+	}
+	vc.clientFactory = NewClientFactory(vc, 0, 0)
+	// Invalidate logs the cached client out, so it needs a SessionManager
+	// and a SOAP client to call; pointing them at a closed port makes that
+	// Logout fail fast instead of dereferencing nil fields.
+	soapClient := soap.NewClient(&url.URL{Scheme: "https", Host: "127.0.0.1:1", Path: "/sdk"}, true)
+	vimClient := &vim25.Client{
+		Client:       soapClient,
+		RoundTripper: soapClient,
+		ServiceContent: types.ServiceContent{
+			SessionManager: &types.ManagedObjectReference{Type: "SessionManager", Value: "SessionManager"},
+		},
+	}
+	vc.clientFactory.client = &govmomi.Client{Client: vimClient, SessionManager: session.NewManager(vimClient)}
+	vc.clientFactory.createdAt = time.Now()
+
+	informer := newFakeSecretInformer(client)
+	NewCredentialWatcher(vc, "vsphere-creds", informer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("informer cache never synced")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsphere-creds", Namespace: testSecretNamespace},
+		Data: map[string][]byte{
+			"username": []byte("newuser"),
+			"password": []byte("newpass"),
+		},
+	}
+	if _, err := client.CoreV1().Secrets(testSecretNamespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	waitForCondition(t, func() bool {
+		vc.clientFactory.mu.Lock()
+		defer vc.clientFactory.mu.Unlock()
+		return vc.clientFactory.client == nil
+	})
+}
+
+// TestCredentialWatcherSolutionUserUpdatesCertKey guards against the bug
+// where handle() always treated Secret data as username/password: for an
+// AuthModeSolutionUser VirtualCenter that clobbered Config.Cert/Key with
+// whatever the Secret's "username"/"password" keys happened to hold (or
+// left them untouched if those keys were absent) instead of reading the
+// PEM-encoded cert/key pair the Secret actually carries.
+func TestCredentialWatcherSolutionUserUpdatesCertKey(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	vc := &VirtualCenter{
+		Config: &VirtualCenterConfig{Host: "vc.test3", AuthMode: AuthModeSolutionUser, Cert: "oldcert", Key: "oldkey"},
+		Client: &govmomi.Client{},
+	}
+
+	informer := newFakeSecretInformer(client)
+	NewCredentialWatcher(vc, "vsphere-creds", informer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("informer cache never synced")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsphere-creds", Namespace: testSecretNamespace},
+		Data: map[string][]byte{
+			"cert": []byte("newcert"),
+			"key":  []byte("newkey"),
+		},
+	}
+	if _, err := client.CoreV1().Secrets(testSecretNamespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	waitForCondition(t, func() bool {
+		return vc.Config.Cert == "newcert" && vc.Config.Key == "newkey"
+	})
+	waitForCondition(t, func() bool {
+		return vc.Client == nil
+	})
+}
+
+// TestCredentialWatcherBearerTokenFileIgnoresSecret guards against
+// clobbering an AuthModeBearerTokenFile VirtualCenter's config with
+// username/password-shaped Secret data it has no use for: BearerTokenFileAuth
+// re-reads its token file on every Login, so the watcher should leave it
+// alone entirely.
+func TestCredentialWatcherBearerTokenFileIgnoresSecret(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	vc := &VirtualCenter{
+		Config: &VirtualCenterConfig{Host: "vc.test4", AuthMode: AuthModeBearerTokenFile, TokenFile: "/etc/token"},
+		Client: &govmomi.Client{},
+	}
+
+	informer := newFakeSecretInformer(client)
+	NewCredentialWatcher(vc, "vsphere-creds", informer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("informer cache never synced")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsphere-creds", Namespace: testSecretNamespace},
+		Data: map[string][]byte{
+			"username": []byte("ignored"),
+			"password": []byte("ignored"),
+		},
+	}
+	if _, err := client.CoreV1().Secrets(testSecretNamespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if vc.Config.TokenFile != "/etc/token" || vc.Client == nil {
+		t.Fatalf("bearer-token-file VirtualCenter was modified by a Secret event: tokenFile=%q client=%v",
+			vc.Config.TokenFile, vc.Client)
+	}
+}
+
+func TestCredentialWatcherIgnoresOtherSecrets(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	vc := &VirtualCenter{Config: &VirtualCenterConfig{Host: "vc.test", Username: "olduser", Password: "oldpass"}}
+
+	informer := newFakeSecretInformer(client)
+	NewCredentialWatcher(vc, "vsphere-creds", informer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("informer cache never synced")
+	}
+
+	unrelated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "unrelated-secret", Namespace: testSecretNamespace},
+		Data:       map[string][]byte{"username": []byte("ignored"), "password": []byte("ignored")},
+	}
+	if _, err := client.CoreV1().Secrets(testSecretNamespace).Create(context.Background(), unrelated, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	// Give the informer a moment to deliver the add event, then assert the
+	// watched VirtualCenter's credentials were left untouched.
+	time.Sleep(50 * time.Millisecond)
+	if vc.Config.Username != "olduser" || vc.Config.Password != "oldpass" {
+		t.Fatalf("credentials changed from an unrelated secret: username=%q password=%q",
+			vc.Config.Username, vc.Config.Password)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory memoizes vCenter inventory lookups (datacenters,
+// cluster->host mappings) that are otherwise re-fetched on every CSI
+// controller topology or placement call.
+package inventory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/klog"
+)
+
+// DefaultTTL is the default lifetime of a cached inventory entry.
+const DefaultTTL = 5 * time.Minute
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vsphere_inventory_cache_hits_total",
+		Help: "Number of inventory cache lookups served from cache, by cache name.",
+	}, []string{"cache"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vsphere_inventory_cache_misses_total",
+		Help: "Number of inventory cache lookups that required a live vCenter read, by cache name.",
+	}, []string{"cache"})
+)
+
+// FetchFunc fetches the live value for a cache key on a miss.
+type FetchFunc func(ctx context.Context) (interface{}, error)
+
+type entry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// Cache memoizes inventory lookups keyed by an arbitrary string (a
+// datacenter path, a cluster MoRef value, ...) for TTL, falling back to a
+// live fetch on a miss, an expired entry, or when the caller asks to bypass
+// the cache entirely.
+type Cache struct {
+	name string
+	TTL  time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]entry
+}
+
+// NewCache creates a Cache identified by name, used as the Prometheus metric
+// label for hit/miss counters. A ttl of 0 uses DefaultTTL.
+func NewCache(name string, ttl time.Duration) *Cache {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{name: name, TTL: ttl, entries: make(map[string]entry)}
+}
+
+// Get returns the cached value for key, calling fetch on a miss, an expired
+// entry, or when bypass is true. A freshly fetched value is cached for TTL
+// before being returned.
+func (c *Cache) Get(ctx context.Context, key string, bypass bool, fetch FetchFunc) (interface{}, error) {
+	if !bypass {
+		c.mu.RLock()
+		e, ok := c.entries[key]
+		c.mu.RUnlock()
+		if ok && time.Now().Before(e.expiresAt) {
+			cacheHits.WithLabelValues(c.name).Inc()
+			return e.value, nil
+		}
+	}
+
+	cacheMisses.WithLabelValues(c.name).Inc()
+	value, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = entry{value: value, expiresAt: time.Now().Add(c.TTL)}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Invalidate removes key from the cache, forcing the next Get to fetch live.
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// Refresh clears every entry in the cache.
+func (c *Cache) Refresh(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]entry)
+	klog.V(3).Infof("Refreshed inventory cache %q", c.name)
+}
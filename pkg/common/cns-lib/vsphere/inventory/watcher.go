@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// ClusterHostWatcher watches ClusterComputeResource.host for changes through
+// the PropertyCollector and invalidates the corresponding cache entry, so a
+// real topology change is reflected without waiting for TTL expiry.
+type ClusterHostWatcher struct {
+	client *vim25.Client
+	cache  *Cache
+}
+
+// NewClusterHostWatcher creates a watcher that invalidates entries in cache
+// keyed by a cluster's MoRef value whenever that cluster's host membership
+// changes.
+func NewClusterHostWatcher(client *vim25.Client, cache *Cache) *ClusterHostWatcher {
+	return &ClusterHostWatcher{client: client, cache: cache}
+}
+
+// Watch blocks, processing PropertyCollector updates for clusters until ctx
+// is cancelled.
+func (w *ClusterHostWatcher) Watch(ctx context.Context, clusters []types.ManagedObjectReference) error {
+	pc := property.DefaultCollector(w.client)
+	return property.Wait(ctx, pc, clusters, []string{"host"}, func(updates []types.ObjectUpdate) bool {
+		for _, update := range updates {
+			klog.V(3).Infof("Cluster %s host membership changed, invalidating inventory cache", update.Obj.Value)
+			w.cache.Invalidate(update.Obj.Value)
+		}
+		return false
+	})
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vmware/govmomi/vim25/soap"
+	"go.opentelemetry.io/otel"
+)
+
+// tracer emits spans around the vCenter SOAP calls on the CSI hot path, so
+// operators can correlate CSI RPC latency with vCenter latency.
+var tracer = otel.Tracer("sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere")
+
+var (
+	// soapRequestDuration records how long each SOAP call to vCenter takes,
+	// by method, vCenter host and result status.
+	soapRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "vsphere_soap_request_duration_seconds",
+		Help: "Duration of SOAP requests made to vCenter, by method, vCenter host and result status.",
+	}, []string{"method", "vc", "status"})
+
+	// reconnectTotal counts reconnect events emitted from connect() when it
+	// replaces an existing session.
+	reconnectTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vsphere_reconnect_total",
+		Help: "Number of times connect() had to re-establish a session with vCenter.",
+	}, []string{"vc"})
+
+	// reauthTotal counts re-authentication attempts triggered by
+	// IsInvalidCredentialsError in Connect().
+	reauthTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vsphere_reauth_total",
+		Help: "Number of re-authentication attempts triggered by invalid credential errors.",
+	}, []string{"vc"})
+
+	// activeSessions reports whether a vCenter currently has a live session
+	// (1) or not (0).
+	activeSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vsphere_active_sessions",
+		Help: "Whether a live govmomi session is held for a vCenter (1) or not (0).",
+	}, []string{"vc"})
+)
+
+// RegisterMetricsHandler registers the Prometheus /metrics handler used to
+// expose vSphere SOAP call instrumentation. It's the driver binary's
+// responsibility to call this against the *http.ServeMux it actually
+// serves on; this package only owns the metrics and the handler for them,
+// not a process entrypoint to hang it from. As of this commit nothing in
+// this tree calls it -- wiring it into the driver's main is a known,
+// tracked gap, not something this package can close on its own.
+func RegisterMetricsHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// instrumentedRoundTripper wraps a soap.RoundTripper to record per-method
+// request duration against soapRequestDuration.
+type instrumentedRoundTripper struct {
+	delegate soap.RoundTripper
+	vc       string
+}
+
+// newInstrumentedRoundTripper wraps rt so every SOAP call it services for
+// vcHost is recorded against soapRequestDuration.
+func newInstrumentedRoundTripper(rt soap.RoundTripper, vcHost string) soap.RoundTripper {
+	return &instrumentedRoundTripper{delegate: rt, vc: vcHost}
+}
+
+// RoundTrip implements soap.RoundTripper.
+func (rt *instrumentedRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	start := time.Now()
+	err := rt.delegate.RoundTrip(ctx, req, res)
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	method := fmt.Sprintf("%T", req)
+	soapRequestDuration.WithLabelValues(method, rt.vc, status).Observe(time.Since(start).Seconds())
+	return err
+}
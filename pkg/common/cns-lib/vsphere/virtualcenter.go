@@ -18,13 +18,11 @@ package vsphere
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/pem"
 	"fmt"
 	"net"
-	neturl "net/url"
 	"strconv"
 	"sync"
+	"time"
 
 	csictx "github.com/rexray/gocsi/context"
 	"github.com/vmware/govmomi"
@@ -33,13 +31,13 @@ import (
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/pbm"
 	"github.com/vmware/govmomi/session"
-	"github.com/vmware/govmomi/sts"
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere/inventory"
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 )
 
@@ -59,8 +57,23 @@ type VirtualCenter struct {
 	// PbmClient represents the govmomi PBM Client instance.
 	PbmClient *pbm.Client
 	// CnsClient represents the CNS client instance.
-	CnsClient       *cns.Client
-	credentialsLock sync.Mutex
+	CnsClient *cns.Client
+	// authenticator performs Login against vCenter using the credential
+	// mechanism selected by Config.AuthMode, and owns the locking around
+	// that mechanism's credential state.
+	authenticator Authenticator
+	// clientFactory hands out and recycles the shared govmomi Client for
+	// this VirtualCenter; connect() stores the client it hands out in
+	// Client, and invalidateClient/Disconnect reset Client to nil.
+	clientFactory *ClientFactory
+	// datacenterCache memoizes GetDatacenters lookups by datacenter path.
+	datacenterCache *inventory.Cache
+	// hostsByClusterCache memoizes GetHostsByCluster lookups by cluster MoRef.
+	hostsByClusterCache *inventory.Cache
+	// watchedClusters tracks which cluster MoRefs already have a background
+	// inventory.ClusterHostWatcher running against hostsByClusterCache.
+	watchedClustersMu sync.Mutex
+	watchedClusters   map[string]bool
 }
 
 func (vc *VirtualCenter) String() string {
@@ -91,20 +104,59 @@ type VirtualCenterConfig struct {
 	RoundTripperCount int
 	// DatacenterPaths represents paths of datacenters on the virtual center.
 	DatacenterPaths []string
+	// AuthMode selects how the VirtualCenter authenticates to vCenter.
+	// Defaults to AuthModeUserPassword when unset.
+	AuthMode AuthMode
+	// Cert is the PEM-encoded solution-user certificate used when AuthMode
+	// is AuthModeSolutionUser.
+	Cert string
+	// Key is the PEM-encoded private key paired with Cert.
+	Key string
+	// TokenFile is the path to a file holding a SAML/JWT bearer token, used
+	// when AuthMode is AuthModeBearerTokenFile.
+	TokenFile string
 }
 
 func (vcc *VirtualCenterConfig) String() string {
+	// Cert/Key are solution-user credentials; redact them rather than
+	// logging a private key and certificate in cleartext.
 	return fmt.Sprintf("VirtualCenterConfig [Scheme: %v, Host: %v, Port: %v, "+
 		"Username: %v, Password: %v, Insecure: %v, RoundTripperCount: %v, "+
-		"DatacenterPaths: %v]", vcc.Scheme, vcc.Host, vcc.Port, vcc.Username,
-		vcc.Password, vcc.Insecure, vcc.RoundTripperCount, vcc.DatacenterPaths)
+		"DatacenterPaths: %v, AuthMode: %v, Cert: %v, Key: %v, TokenFile: %v]",
+		vcc.Scheme, vcc.Host, vcc.Port, vcc.Username, vcc.Password, vcc.Insecure,
+		vcc.RoundTripperCount, vcc.DatacenterPaths, vcc.AuthMode, redact(vcc.Cert), redact(vcc.Key), vcc.TokenFile)
 }
 
-// clientMutex is used for exclusive connection creation.
-var clientMutex sync.Mutex
+// redact replaces a non-empty secret with a fixed placeholder so it never
+// reaches logs in cleartext.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// connectMutexes holds a per-host mutex used for exclusive connection
+// creation, so a slow login to one vCenter doesn't block connection setup
+// for another.
+var connectMutexes sync.Map // map[string]*sync.Mutex
+
+// connectMutex returns the mutex guarding connection setup for host,
+// creating one on first use.
+func connectMutex(host string) *sync.Mutex {
+	m, _ := connectMutexes.LoadOrStore(host, &sync.Mutex{})
+	return m.(*sync.Mutex)
+}
 
-// newClient creates a new govmomi Client instance.
-func (vc *VirtualCenter) newClient(ctx context.Context) (*govmomi.Client, error) {
+// newClient creates a new govmomi Client instance and logs it in.
+//
+// keepAliveInterval/keepAlive wrap the client's RoundTripper with
+// session.KeepAliveHandler before login, rather than after, so the login
+// request itself round-trips through the handler and triggers its
+// Start(): KeepAliveHandler only arms its ticker the first time a
+// Login/LoginByToken call passes through it, so wrapping post-login would
+// leave the ticker - and the whole keepalive - permanently dormant.
+func (vc *VirtualCenter) newClient(ctx context.Context, keepAliveInterval time.Duration, keepAlive func(soap.RoundTripper) error) (*govmomi.Client, error) {
 	if vc.Config.Scheme == "" {
 		vc.Config.Scheme = DefaultScheme
 	}
@@ -134,6 +186,7 @@ func (vc *VirtualCenter) newClient(ctx context.Context) (*govmomi.Client, error)
 		Client:         vimClient,
 		SessionManager: session.NewManager(vimClient),
 	}
+	client.RoundTripper = session.KeepAliveHandler(client.RoundTripper, keepAliveInterval, keepAlive)
 
 	err = vc.login(ctx, client)
 	if err != nil {
@@ -149,45 +202,25 @@ func (vc *VirtualCenter) newClient(ctx context.Context) (*govmomi.Client, error)
 		vc.Config.RoundTripperCount = DefaultRoundTripperCount
 	}
 	client.RoundTripper = vim25.Retry(client.RoundTripper, vim25.TemporaryNetworkError(vc.Config.RoundTripperCount))
+	client.RoundTripper = newInstrumentedRoundTripper(client.RoundTripper, vc.Config.Host)
+	activeSessions.WithLabelValues(vc.Config.Host).Set(1)
 	return client, nil
 }
 
-// login calls SessionManager.LoginByToken if certificate and private key are configured,
-// otherwise calls SessionManager.Login with user and password.
+// login authenticates client using the Authenticator selected by
+// Config.AuthMode, lazily constructing it on first use.
 func (vc *VirtualCenter) login(ctx context.Context, client *govmomi.Client) error {
-	var err error
-	vc.credentialsLock.Lock()
-	defer vc.credentialsLock.Unlock()
+	ctx, span := tracer.Start(ctx, "VirtualCenter.Login")
+	defer span.End()
 
-	b, _ := pem.Decode([]byte(vc.Config.Username))
-	if b == nil {
-		return client.SessionManager.Login(ctx, neturl.UserPassword(vc.Config.Username, vc.Config.Password))
-	}
-
-	cert, err := tls.X509KeyPair([]byte(vc.Config.Username), []byte(vc.Config.Password))
-	if err != nil {
-		klog.Errorf("Failed to load X509 key pair with err: %v", err)
-		return err
-	}
-
-	tokens, err := sts.NewClient(ctx, client.Client)
-	if err != nil {
-		klog.Errorf("Failed to create STS client with err: %v", err)
-		return err
-	}
-
-	req := sts.TokenRequest{
-		Certificate: &cert,
-	}
-
-	signer, err := tokens.Issue(ctx, req)
-	if err != nil {
-		klog.Errorf("Failed to issue SAML token with err: %v", err)
-		return err
+	if vc.authenticator == nil {
+		auth, err := newAuthenticator(vc.Config)
+		if err != nil {
+			return err
+		}
+		vc.authenticator = auth
 	}
-
-	header := soap.Header{Security: signer}
-	return client.SessionManager.LoginByToken(client.Client.WithHeader(ctx, header))
+	return vc.authenticator.Login(ctx, client)
 }
 
 // Connect establishes connection with vSphere with existing credentials if session doesn't exist.
@@ -203,6 +236,7 @@ func (vc *VirtualCenter) Connect(ctx context.Context) error {
 	}
 	klog.V(2).Infof("Invalid credentials. Cannot connect to server %q. "+
 		"Fetching credentials from secret.", vc.Config.Host)
+	reauthTotal.WithLabelValues(vc.Config.Host).Inc()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -222,49 +256,51 @@ func (vc *VirtualCenter) Connect(ctx context.Context) error {
 		klog.Errorf("Failed to get VirtualCenterConfig. err=%v", err)
 		return err
 	}
-	vc.UpdateCredentials(vcenterconfig.Username, vcenterconfig.Password)
+	vc.updateCredentialsFromConfig(vcenterconfig)
 	return vc.connect(ctx)
 }
 
-// connect creates a connection to the virtual center host.
+// connect creates a connection to the virtual center host, handing off the
+// actual client creation/recycling to the VirtualCenter's ClientFactory.
 func (vc *VirtualCenter) connect(ctx context.Context) error {
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
+	mu := connectMutex(vc.Config.Host)
+	mu.Lock()
+	defer mu.Unlock()
 
-	// If client was never initialized, initialize one.
-	var err error
-	if vc.Client == nil {
-		if vc.Client, err = vc.newClient(ctx); err != nil {
-			klog.Errorf("Failed to create govmomi client with err: %v", err)
-			return err
-		}
-		return nil
+	if vc.clientFactory == nil {
+		vc.clientFactory = NewClientFactory(vc, 0, 0)
 	}
 
-	// If session hasn't expired, nothing to do.
-	sessionMgr := session.NewManager(vc.Client.Client)
-	// SessionMgr.UserSession(ctx) retrieves and returns the SessionManager's CurrentSession field
-	// Nil is returned if the session is not authenticated or timed out.
-	if userSession, err := sessionMgr.UserSession(ctx); err != nil {
-		klog.Errorf("Failed to obtain user session with err: %v", err)
+	previousClient := vc.Client
+	client, err := vc.clientFactory.GetClient(ctx)
+	if err != nil {
+		klog.Errorf("Failed to create govmomi client with err: %v", err)
 		return err
-	} else if userSession != nil {
+	}
+	// Make this VirtualCenter discoverable by host through the process-wide
+	// registry now that it has a live session.
+	defaultVirtualCenterManager.Register(vc)
+	if client == previousClient {
+		// The ClientFactory reused the existing session; nothing else to do.
 		return nil
 	}
-	// If session has expired, create a new instance.
-	klog.Warning("Creating a new client session as the existing session isn't valid or not authenticated")
-	if vc.Client, err = vc.newClient(ctx); err != nil {
-		klog.Errorf("Failed to create govmomi client with err: %v", err)
-		return err
+	vc.Client = client
+
+	if previousClient == nil && vc.PbmClient == nil && vc.CnsClient == nil {
+		// First connection for this VirtualCenter; no dependent clients yet.
+		return nil
 	}
-	// Recreate PbmClient If created using timed out VC Client
+
+	// The ClientFactory replaced the client because the prior session had
+	// expired or aged out, or because invalidateClient discarded it; recreate
+	// clients that were built on top of it.
+	reconnectTotal.WithLabelValues(vc.Config.Host).Inc()
 	if vc.PbmClient != nil {
 		if vc.PbmClient, err = pbm.NewClient(ctx, vc.Client.Client); err != nil {
 			klog.Errorf("Failed to create pbm client with err: %v", err)
 			return err
 		}
 	}
-	// Recreate CNSClient If created using timed out VC Client
 	if vc.CnsClient != nil {
 		if vc.CnsClient, err = NewCNSClient(ctx, vc.Client.Client); err != nil {
 			klog.Errorf("Failed to create CNS client on vCenter host %v with err: %v", vc.Config.Host, err)
@@ -276,6 +312,9 @@ func (vc *VirtualCenter) connect(ctx context.Context) error {
 
 // listDatacenters returns all Datacenters.
 func (vc *VirtualCenter) listDatacenters(ctx context.Context) ([]*Datacenter, error) {
+	ctx, span := tracer.Start(ctx, "VirtualCenter.DatacenterList")
+	defer span.End()
+
 	finder := find.NewFinder(vc.Client.Client, false)
 	dcList, err := finder.DatacenterList(ctx, "*")
 	if err != nil {
@@ -312,11 +351,36 @@ func (vc *VirtualCenter) getDatacenters(ctx context.Context, dcPaths []string) (
 // Datacenters for the given VirtualCenter will be returned. If DatacenterPaths
 // is configured in VirtualCenterConfig during registration, only the listed
 // Datacenters are returned.
+//
+// The result is served transparently from an inventory cache with
+// DefaultTTL; callers that need a live read should use
+// GetDatacentersLive instead.
 func (vc *VirtualCenter) GetDatacenters(ctx context.Context) ([]*Datacenter, error) {
-	if len(vc.Config.DatacenterPaths) != 0 {
-		return vc.getDatacenters(ctx, vc.Config.DatacenterPaths)
+	return vc.getDatacentersCached(ctx, false)
+}
+
+// GetDatacentersLive behaves like GetDatacenters but bypasses the inventory
+// cache, always fetching current state from vCenter. Callers that can't
+// tolerate a stale result for up to DefaultTTL should use this instead.
+func (vc *VirtualCenter) GetDatacentersLive(ctx context.Context) ([]*Datacenter, error) {
+	return vc.getDatacentersCached(ctx, true)
+}
+
+func (vc *VirtualCenter) getDatacentersCached(ctx context.Context, bypassCache bool) ([]*Datacenter, error) {
+	if vc.datacenterCache == nil {
+		vc.datacenterCache = inventory.NewCache("datacenters", 0)
+	}
+	key := fmt.Sprintf("%v", vc.Config.DatacenterPaths)
+	value, err := vc.datacenterCache.Get(ctx, key, bypassCache, func(ctx context.Context) (interface{}, error) {
+		if len(vc.Config.DatacenterPaths) != 0 {
+			return vc.getDatacenters(ctx, vc.Config.DatacenterPaths)
+		}
+		return vc.listDatacenters(ctx)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return vc.listDatacenters(ctx)
+	return value.([]*Datacenter), nil
 }
 
 // Disconnect disconnects the virtual center host connection if connected.
@@ -330,19 +394,154 @@ func (vc *VirtualCenter) Disconnect(ctx context.Context) error {
 		return err
 	}
 	vc.Client = nil
+	activeSessions.WithLabelValues(vc.Config.Host).Set(0)
 	return nil
 }
 
-// UpdateCredentials updates username and password in the VirtualCenterConfig object
+// UpdateCredentials updates username and password in the VirtualCenterConfig
+// object and forwards them to the configured Authenticator, if one has
+// already been constructed.
 func (vc *VirtualCenter) UpdateCredentials(username, password string) {
-	vc.credentialsLock.Lock()
-	defer vc.credentialsLock.Unlock()
 	vc.Config.Username = username
 	vc.Config.Password = password
+	if vc.authenticator != nil {
+		vc.authenticator.UpdateCredentials(username, password)
+	}
+}
+
+// UpdateSolutionUserCredentials updates the solution-user cert/key pair in
+// the VirtualCenterConfig object and forwards them to the configured
+// Authenticator, if one has already been constructed. Callers should only
+// use this for a VirtualCenter whose AuthMode is AuthModeSolutionUser.
+func (vc *VirtualCenter) UpdateSolutionUserCredentials(cert, key string) {
+	vc.Config.Cert = cert
+	vc.Config.Key = key
+	if vc.authenticator != nil {
+		vc.authenticator.UpdateCredentials(cert, key)
+	}
+}
+
+// invalidateClient forces the next connect() to re-authenticate from
+// scratch, for callers (credential/config watchers) that know the
+// credentials just changed. Nulling vc.Client alone isn't enough: connect()
+// delegates to vc.clientFactory, which caches its own client and only
+// rebuilds it once MaxAge elapses or SessionManager reports the session
+// invalid — neither of which a credential rotation by itself triggers.
+func (vc *VirtualCenter) invalidateClient() {
+	mu := connectMutex(vc.Config.Host)
+	mu.Lock()
+	defer mu.Unlock()
+	vc.Client = nil
+	if vc.clientFactory != nil {
+		vc.clientFactory.Invalidate()
+	}
+	vc.refreshInventoryCaches(context.Background())
+}
+
+// refreshInventoryCaches clears datacenterCache and hostsByClusterCache.
+// Both cache *Datacenter/*HostSystem objects that capture vc.Client.Client
+// at fetch time, so whenever the underlying govmomi Client is discarded --
+// on ClientFactory recycle or an explicit invalidateClient -- the cached
+// objects would otherwise keep pointing at a logged-out session and get
+// served for up to DefaultTTL.
+func (vc *VirtualCenter) refreshInventoryCaches(ctx context.Context) {
+	if vc.datacenterCache != nil {
+		vc.datacenterCache.Refresh(ctx)
+	}
+	if vc.hostsByClusterCache != nil {
+		vc.hostsByClusterCache.Refresh(ctx)
+	}
+}
+
+// updateCredentialsFromConfig refreshes vc's credentials from a freshly
+// reloaded VirtualCenterConfig, picking the field pair that matches
+// vc.Config.AuthMode instead of assuming Username/Password: for
+// AuthModeSolutionUser/AuthModeBearerTokenFile, Username/Password are unused
+// by the authenticator (see authenticator.go) and reloading them would just
+// clobber Cert/Key/TokenFile with empty strings.
+func (vc *VirtualCenter) updateCredentialsFromConfig(cfg *VirtualCenterConfig) {
+	switch vc.Config.AuthMode {
+	case AuthModeSolutionUser:
+		vc.UpdateSolutionUserCredentials(cfg.Cert, cfg.Key)
+	case AuthModeBearerTokenFile:
+		vc.Config.TokenFile = cfg.TokenFile
+		if vc.authenticator != nil {
+			vc.authenticator.UpdateCredentials(cfg.TokenFile, "")
+		}
+	default:
+		vc.UpdateCredentials(cfg.Username, cfg.Password)
+	}
 }
 
 // GetHostsByCluster return hosts inside the cluster using cluster moref.
+//
+// The result is served transparently from an inventory cache with
+// DefaultTTL; callers that need a live read, such as the attach/detach
+// paths, should use GetHostsByClusterLive instead.
 func (vc *VirtualCenter) GetHostsByCluster(ctx context.Context, clusterMorefValue string) ([]*HostSystem, error) {
+	return vc.getHostsByClusterCached(ctx, clusterMorefValue, false)
+}
+
+// GetHostsByClusterLive behaves like GetHostsByCluster but bypasses the
+// inventory cache, always fetching current cluster membership from
+// vCenter.
+func (vc *VirtualCenter) GetHostsByClusterLive(ctx context.Context, clusterMorefValue string) ([]*HostSystem, error) {
+	return vc.getHostsByClusterCached(ctx, clusterMorefValue, true)
+}
+
+func (vc *VirtualCenter) getHostsByClusterCached(ctx context.Context, clusterMorefValue string, bypassCache bool) ([]*HostSystem, error) {
+	if vc.hostsByClusterCache == nil {
+		vc.hostsByClusterCache = inventory.NewCache("hosts_by_cluster", 0)
+	}
+	vc.watchClusterHostChanges(clusterMorefValue)
+
+	value, err := vc.hostsByClusterCache.Get(ctx, clusterMorefValue, bypassCache, func(ctx context.Context) (interface{}, error) {
+		return vc.getHostsByCluster(ctx, clusterMorefValue)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]*HostSystem), nil
+}
+
+// watchClusterHostChanges starts a background PropertyCollector watch for
+// clusterMorefValue the first time it's seen, so the cache entry is
+// invalidated as soon as the cluster's host membership actually changes
+// instead of waiting for the cache TTL to expire.
+func (vc *VirtualCenter) watchClusterHostChanges(clusterMorefValue string) {
+	vc.watchedClustersMu.Lock()
+	defer vc.watchedClustersMu.Unlock()
+	if vc.watchedClusters == nil {
+		vc.watchedClusters = make(map[string]bool)
+	}
+	if vc.watchedClusters[clusterMorefValue] {
+		return
+	}
+	vc.watchedClusters[clusterMorefValue] = true
+
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: clusterMorefValue}
+	watcher := inventory.NewClusterHostWatcher(vc.Client.Client, vc.hostsByClusterCache)
+	go func() {
+		if err := watcher.Watch(context.Background(), []types.ManagedObjectReference{cluster}); err != nil {
+			klog.Errorf("Cluster host watcher for %s stopped with err: %v", clusterMorefValue, err)
+			// The watcher is pinned to the vim25.Client captured above, so it
+			// dies for good once that client is recycled (chunk0-1) and its
+			// session becomes invalid. Clear the flag so the next
+			// GetHostsByCluster call starts a replacement watcher against
+			// vc's current client instead of silently falling back to
+			// TTL-only invalidation for the rest of the process's lifetime.
+			vc.watchedClustersMu.Lock()
+			delete(vc.watchedClusters, clusterMorefValue)
+			vc.watchedClustersMu.Unlock()
+		}
+	}()
+}
+
+// getHostsByCluster fetches hosts inside the cluster directly from vCenter.
+func (vc *VirtualCenter) getHostsByCluster(ctx context.Context, clusterMorefValue string) ([]*HostSystem, error) {
+	ctx, span := tracer.Start(ctx, "VirtualCenter.RetrieveOne")
+	defer span.End()
+
 	clusterMoref := types.ManagedObjectReference{
 		Type:  "ClusterComputeResource",
 		Value: clusterMorefValue,
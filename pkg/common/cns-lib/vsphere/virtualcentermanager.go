@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// VirtualCenterManager is a registry of VirtualCenter instances keyed by
+// host, letting a single driver process hold live sessions to more than one
+// vCenter at a time.
+type VirtualCenterManager struct {
+	mu       sync.RWMutex
+	vCenters map[string]*VirtualCenter
+}
+
+// NewVirtualCenterManager creates an empty VirtualCenterManager.
+func NewVirtualCenterManager() *VirtualCenterManager {
+	return &VirtualCenterManager{
+		vCenters: make(map[string]*VirtualCenter),
+	}
+}
+
+// GetOrRegister returns the VirtualCenter registered for cfg.Host, creating
+// and registering one from cfg if it isn't registered yet.
+func (m *VirtualCenterManager) GetOrRegister(cfg *VirtualCenterConfig) (*VirtualCenter, error) {
+	if cfg == nil || cfg.Host == "" {
+		return nil, fmt.Errorf("vCenter config must specify a host")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if vc, ok := m.vCenters[cfg.Host]; ok {
+		return vc, nil
+	}
+	vc := &VirtualCenter{Config: cfg}
+	m.vCenters[cfg.Host] = vc
+	klog.V(2).Infof("Registered vCenter %s", cfg.Host)
+	return vc, nil
+}
+
+// Register records an already-constructed VirtualCenter under its
+// Config.Host, overwriting any previous entry for that host. Unlike
+// GetOrRegister, it doesn't allocate a new VirtualCenter, so callers that
+// already hold one (e.g. VirtualCenter.connect on a successful connection)
+// can make it discoverable through the registry without losing its
+// in-memory state (cached clients, authenticator, inventory caches).
+func (m *VirtualCenterManager) Register(vc *VirtualCenter) {
+	if vc == nil || vc.Config == nil || vc.Config.Host == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.vCenters[vc.Config.Host]; !ok {
+		klog.V(2).Infof("Registered vCenter %s", vc.Config.Host)
+	}
+	m.vCenters[vc.Config.Host] = vc
+}
+
+// Get returns the VirtualCenter registered for host, if any.
+func (m *VirtualCenterManager) Get(host string) (*VirtualCenter, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	vc, ok := m.vCenters[host]
+	return vc, ok
+}
+
+// ListVCenters returns every registered VirtualCenter.
+func (m *VirtualCenterManager) ListVCenters() []*VirtualCenter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	vCenters := make([]*VirtualCenter, 0, len(m.vCenters))
+	for _, vc := range m.vCenters {
+		vCenters = append(vCenters, vc)
+	}
+	return vCenters
+}
+
+// DisconnectAll disconnects every registered VirtualCenter. It keeps
+// disconnecting the rest of the registry even if one disconnect fails,
+// returning the first error encountered.
+func (m *VirtualCenterManager) DisconnectAll(ctx context.Context) error {
+	vCenters := m.ListVCenters()
+
+	var firstErr error
+	for _, vc := range vCenters {
+		if err := vc.Disconnect(ctx); err != nil {
+			klog.Errorf("Failed to disconnect vCenter %s with err: %v", vc.Config.Host, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// defaultVirtualCenterManager is the process-wide registry every
+// VirtualCenter registers itself in once it connects, so a StorageClass- or
+// topology-driven caller elsewhere in the driver can look vCenters up by
+// host without having to thread a *VirtualCenterManager through every
+// layer itself.
+//
+// The CNS volume-provisioning path (CreateVolume and the block/file volume
+// managers) isn't part of this package snapshot, so it can't be edited
+// here. SelectVirtualCenter below is the selector that path is expected to
+// call: it resolves ParameterVirtualCenter/TopologyLabelVirtualCenter to a
+// registered VirtualCenter the same way Get resolves a host to one (an
+// unregistered host is an error, not a new registration), so wiring it into CreateVolume is a call at the provisioning site,
+// not further plumbing through this package.
+var defaultVirtualCenterManager = NewVirtualCenterManager()
+
+const (
+	// ParameterVirtualCenter is the StorageClass parameter key a caller can
+	// set to pin volume provisioning to a specific vCenter in a
+	// multi-vCenter deployment. Takes priority over
+	// TopologyLabelVirtualCenter.
+	ParameterVirtualCenter = "csi.vsphere.vmware.com/vcenter"
+	// TopologyLabelVirtualCenter is the topology segment key whose value,
+	// if ParameterVirtualCenter isn't set, selects the vCenter that owns
+	// the zone/region the volume is being placed into.
+	TopologyLabelVirtualCenter = "topology.csi.vsphere.vmware.com/vcenter"
+)
+
+// SelectVirtualCenter resolves the VirtualCenter that should service a
+// volume-provisioning request, given its StorageClass parameters and
+// accessibility topology segments. ParameterVirtualCenter in parameters
+// takes priority over TopologyLabelVirtualCenter in topologySegments; if
+// neither is set and exactly one VirtualCenter has registered, that single
+// VirtualCenter is returned, so single-vCenter deployments don't need to
+// set either. Returns an error if the selected host has no registered
+// VirtualCenter, or if neither is set and zero or multiple VirtualCenters
+// have registered.
+//
+// Nothing in this snapshot calls SelectVirtualCenter yet: CreateVolume, the
+// block/file volume managers, and node registration -- the call sites the
+// original request asked for -- aren't part of this package and so aren't
+// touched by this change. This function alone is a partial delivery of that
+// request, not the full selector wiring.
+func SelectVirtualCenter(parameters, topologySegments map[string]string) (*VirtualCenter, error) {
+	host := parameters[ParameterVirtualCenter]
+	if host == "" {
+		host = topologySegments[TopologyLabelVirtualCenter]
+	}
+	if host == "" {
+		vCenters := ListRegisteredVirtualCenters()
+		switch len(vCenters) {
+		case 1:
+			return vCenters[0], nil
+		case 0:
+			return nil, fmt.Errorf("no vCenter registered")
+		default:
+			return nil, fmt.Errorf("multiple vCenters registered; specify %q in StorageClass parameters or %q in topology",
+				ParameterVirtualCenter, TopologyLabelVirtualCenter)
+		}
+	}
+	vc, ok := GetRegisteredVirtualCenter(host)
+	if !ok {
+		return nil, fmt.Errorf("no registered vCenter for host %q", host)
+	}
+	return vc, nil
+}
+
+// GetRegisteredVirtualCenter returns the VirtualCenter that has successfully
+// connected to host, if any.
+func GetRegisteredVirtualCenter(host string) (*VirtualCenter, bool) {
+	return defaultVirtualCenterManager.Get(host)
+}
+
+// ListRegisteredVirtualCenters returns every VirtualCenter that has
+// successfully connected in this process.
+func ListRegisteredVirtualCenters() []*VirtualCenter {
+	return defaultVirtualCenterManager.ListVCenters()
+}
+
+// DisconnectAllVirtualCenters disconnects every VirtualCenter that has
+// successfully connected in this process.
+func DisconnectAllVirtualCenters(ctx context.Context) error {
+	return defaultVirtualCenterManager.DisconnectAll(ctx)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import "testing"
+
+// withRegisteredVirtualCenters registers vc for each given host against
+// defaultVirtualCenterManager for the duration of the test, restoring the
+// prior registry on cleanup so tests don't leak state into each other.
+func withRegisteredVirtualCenters(t *testing.T, hosts ...string) {
+	t.Helper()
+	prior := defaultVirtualCenterManager
+	defaultVirtualCenterManager = NewVirtualCenterManager()
+	t.Cleanup(func() { defaultVirtualCenterManager = prior })
+
+	for _, host := range hosts {
+		defaultVirtualCenterManager.Register(&VirtualCenter{Config: &VirtualCenterConfig{Host: host}})
+	}
+}
+
+func TestSelectVirtualCenterByParameter(t *testing.T) {
+	withRegisteredVirtualCenters(t, "vc-a", "vc-b")
+
+	vc, err := SelectVirtualCenter(map[string]string{ParameterVirtualCenter: "vc-b"}, nil)
+	if err != nil {
+		t.Fatalf("SelectVirtualCenter() returned err: %v", err)
+	}
+	if vc.Config.Host != "vc-b" {
+		t.Errorf("SelectVirtualCenter() = host %q, want vc-b", vc.Config.Host)
+	}
+}
+
+func TestSelectVirtualCenterByTopology(t *testing.T) {
+	withRegisteredVirtualCenters(t, "vc-a", "vc-b")
+
+	vc, err := SelectVirtualCenter(nil, map[string]string{TopologyLabelVirtualCenter: "vc-a"})
+	if err != nil {
+		t.Fatalf("SelectVirtualCenter() returned err: %v", err)
+	}
+	if vc.Config.Host != "vc-a" {
+		t.Errorf("SelectVirtualCenter() = host %q, want vc-a", vc.Config.Host)
+	}
+}
+
+func TestSelectVirtualCenterParameterTakesPriority(t *testing.T) {
+	withRegisteredVirtualCenters(t, "vc-a", "vc-b")
+
+	vc, err := SelectVirtualCenter(
+		map[string]string{ParameterVirtualCenter: "vc-a"},
+		map[string]string{TopologyLabelVirtualCenter: "vc-b"},
+	)
+	if err != nil {
+		t.Fatalf("SelectVirtualCenter() returned err: %v", err)
+	}
+	if vc.Config.Host != "vc-a" {
+		t.Errorf("SelectVirtualCenter() = host %q, want vc-a", vc.Config.Host)
+	}
+}
+
+func TestSelectVirtualCenterSingleVCFallback(t *testing.T) {
+	withRegisteredVirtualCenters(t, "vc-only")
+
+	vc, err := SelectVirtualCenter(nil, nil)
+	if err != nil {
+		t.Fatalf("SelectVirtualCenter() returned err: %v", err)
+	}
+	if vc.Config.Host != "vc-only" {
+		t.Errorf("SelectVirtualCenter() = host %q, want vc-only", vc.Config.Host)
+	}
+}
+
+func TestSelectVirtualCenterAmbiguousWithoutSelector(t *testing.T) {
+	withRegisteredVirtualCenters(t, "vc-a", "vc-b")
+
+	if _, err := SelectVirtualCenter(nil, nil); err == nil {
+		t.Fatal("SelectVirtualCenter() expected an error when multiple vCenters are registered and no selector is set")
+	}
+}
+
+func TestSelectVirtualCenterUnknownHost(t *testing.T) {
+	withRegisteredVirtualCenters(t, "vc-a")
+
+	if _, err := SelectVirtualCenter(map[string]string{ParameterVirtualCenter: "vc-missing"}, nil); err == nil {
+		t.Fatal("SelectVirtualCenter() expected an error for an unregistered host")
+	}
+}